@@ -0,0 +1,184 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/sirupsen/logrus"
+)
+
+// cniEventTimeout bounds each request cmdAdd makes to the node agent. With
+// retries, cmdAdd can wait up to (retries+1) times this, plus backoff, before
+// falling back to talking to the apiserver directly.
+const cniEventTimeout = 2 * time.Second
+
+// defaultCNIEventAddress is the Unix domain socket a node-local agent listens
+// on for CNI events, avoiding an apiserver round trip from inside cmdAdd.
+const defaultCNIEventAddress = "/var/run/istio-cni/cni.sock"
+
+// CNIEvent is what cmdAdd reports to the node agent: everything the agent
+// needs to look up (or confirm) the pod in its cache, without the plugin
+// itself talking to the apiserver. The agent does not program the redirect;
+// cmdAdd still does that itself.
+type CNIEvent struct {
+	ContainerID   string   `json:"containerId"`
+	Netns         string   `json:"netns"`
+	PodName       string   `json:"podName"`
+	PodNamespace  string   `json:"podNamespace"`
+	PrevResultIPs []string `json:"prevResultIps"`
+	Args          string   `json:"args"`
+}
+
+// CNIEventResponse is the agent's reply to a pushed CNIEvent: the pod info
+// the plugin would otherwise have fetched from the apiserver itself,
+// including whether the pod has an Istio proxy container.
+type CNIEventResponse struct {
+	HasProxy    bool              `json:"hasProxy"`
+	Containers  []string          `json:"containers"`
+	Annotations map[string]string `json:"annotations"`
+	Ports       []string          `json:"ports"`
+	ProxyUID    string            `json:"proxyUid"`
+	ProxyGID    string            `json:"proxyGid"`
+}
+
+// CNIEventClient pushes CNIEvents to the node agent over a Unix domain
+// socket, retrying failed requests before the caller falls back to
+// talking to the apiserver directly.
+type CNIEventClient struct {
+	address    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	logger     *logrus.Entry
+}
+
+// NewCNIEventClient builds a client for the UDS at address, or
+// defaultCNIEventAddress if address is empty.
+func NewCNIEventClient(address string, timeout time.Duration, logger *logrus.Entry) *CNIEventClient {
+	if address == "" {
+		address = defaultCNIEventAddress
+	}
+	return &CNIEventClient{
+		address: address,
+		httpClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", address)
+				},
+			},
+		},
+		retries: 2,
+		backoff: 100 * time.Millisecond,
+		logger:  logger,
+	}
+}
+
+// SocketPresent reports whether the agent's socket exists, so callers can
+// skip straight to the apiserver fallback when no agent is running.
+func (c *CNIEventClient) SocketPresent() bool {
+	_, err := os.Stat(c.address)
+	return err == nil
+}
+
+// PushCNIEvent POSTs event to the node agent, retrying any request error or
+// non-200 reply with a short backoff, and decodes its CNIEventResponse.
+func (c *CNIEventClient) PushCNIEvent(event *CNIEvent) (*CNIEventResponse, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CNI event: %v", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			c.logger.Debugf("Retrying CNI event push (attempt %d): %v", attempt, lastErr)
+			time.Sleep(c.backoff * time.Duration(attempt))
+		}
+
+		resp, err := c.httpClient.Post("http://unix/cni-event", "application/json", bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			lastErr = fmt.Errorf("node agent returned status %d", resp.StatusCode)
+			resp.Body.Close()
+			continue
+		}
+
+		var cniResp CNIEventResponse
+		err = json.NewDecoder(resp.Body).Decode(&cniResp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode node agent response: %v", err)
+		}
+		return &cniResp, nil
+	}
+
+	return nil, fmt.Errorf("failed to push CNI event after %d attempts: %v", c.retries+1, lastErr)
+}
+
+// getPodInfo resolves the pod info cmdAdd needs to decide on a redirect. It
+// prefers the node agent's cache over the UDS, since that avoids an
+// apiserver round trip from inside cmdAdd; if the agent's socket isn't
+// present, or pushing the event fails, it falls back to today's direct
+// apiserver lookup.
+func getPodInfo(conf *PluginConf, args *skel.CmdArgs, podName, podNamespace string, logger *logrus.Entry) (
+	hasProxy bool, containers []string, annotations map[string]string, ports []string, proxyUID, proxyGID string, err error) {
+
+	eventClient := NewCNIEventClient(conf.CNIEventAddress, cniEventTimeout, logger)
+	if eventClient.SocketPresent() {
+		event := &CNIEvent{
+			ContainerID:  args.ContainerID,
+			Netns:        args.Netns,
+			PodName:      podName,
+			PodNamespace: podNamespace,
+			Args:         string(args.Args),
+		}
+		if conf.PrevResult != nil {
+			for _, ip := range conf.PrevResult.IPs {
+				event.PrevResultIPs = append(event.PrevResultIPs, ip.Address.String())
+			}
+		}
+
+		resp, pushErr := eventClient.PushCNIEvent(event)
+		if pushErr == nil {
+			return resp.HasProxy, resp.Containers, resp.Annotations, resp.Ports, resp.ProxyUID, resp.ProxyGID, nil
+		}
+		logger.Warnf("Failed to push CNI event to node agent, falling back to direct apiserver lookup: %v", pushErr)
+	}
+
+	kcLogger := kubeclientScope.WithFields(logger.Data)
+	client, err := newKubeClient(*conf, kcLogger)
+	if err != nil {
+		return false, nil, nil, nil, "", "", err
+	}
+	kcLogger.WithField("client", client).Debug("Created Kubernetes client")
+
+	hasProxy, containers, _, annotations, ports, proxyUID, proxyGID, err = getKubePodInfo(client, podName, podNamespace)
+	return hasProxy, containers, annotations, ports, proxyUID, proxyGID, err
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// InterceptRuleMgr is implemented by each traffic-interception backend. A
+// Redirect delegates the mechanics of installing and removing its rules to
+// one of these so that redirect.go stays backend-agnostic.
+type InterceptRuleMgr interface {
+	// Program installs the rules that redirect the pod's inbound traffic on
+	// rdrct.interfaces into the proxy, inside the netns at netnsPath. The
+	// script backend passes rdrct.ports to the script as its inbound ports;
+	// the iptables and tproxy backends exclude rdrct.ports from redirection.
+	Program(netnsPath string, rdrct *Redirect) error
+	// Cleanup removes whatever Program installed.
+	Cleanup(netnsPath string, rdrct *Redirect) error
+}
+
+// newInterceptRuleMgr selects the InterceptRuleMgr backend named by
+// PluginConf.InterceptType, defaulting to the legacy shell-script backend so
+// existing deployments keep working unmodified.
+func newInterceptRuleMgr(interceptType string, logger *logrus.Entry) (InterceptRuleMgr, error) {
+	switch interceptType {
+	case "", "script":
+		return &scriptRuleMgr{logger: logger}, nil
+	case "iptables":
+		return &iptRuleMgr{logger: logger}, nil
+	case "tproxy":
+		return &iptRuleMgr{logger: logger, tproxy: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown intercept_type %q", interceptType)
+	}
+}
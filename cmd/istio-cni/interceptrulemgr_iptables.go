@@ -0,0 +1,140 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/coreos/go-iptables/iptables"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+// istioInboundChain and istioTproxyChain are the chains this backend owns;
+// they're kept separate from whatever istio-iptables.sh uses so the two
+// backends can never collide.
+const (
+	istioInboundChain = "ISTIO_IN_REDIRECT"
+	istioTproxyChain  = "ISTIO_TPROXY"
+)
+
+// iptRuleMgr programs REDIRECT (or TPROXY, when tproxy is set) rules
+// directly from Go using github.com/coreos/go-iptables, entering the pod's
+// netns instead of exec'ing istio-iptables.sh. This avoids a fork/exec per
+// pod and lets rule generation be unit tested without a running kernel netns.
+type iptRuleMgr struct {
+	logger *logrus.Entry
+	tproxy bool
+}
+
+func (r *iptRuleMgr) Program(netnsPath string, rdrct *Redirect) error {
+	table, chain := r.tableAndChain()
+	return r.withNetNS(netnsPath, func(ipt *iptables.IPTables) error {
+		if err := ipt.NewChain(table, chain); err != nil && !isChainExistsErr(err) {
+			return fmt.Errorf("failed to create chain %s/%s: %v", table, chain, err)
+		}
+
+		for _, iface := range rdrct.interfaces {
+			rule := r.redirectRule(rdrct, iface)
+			if err := ipt.AppendUnique(table, chain, rule...); err != nil {
+				return fmt.Errorf("failed to append redirect rule for %s: %v", iface, err)
+			}
+		}
+
+		if err := ipt.AppendUnique(table, "PREROUTING", "-j", chain); err != nil {
+			return fmt.Errorf("failed to hook %s into PREROUTING: %v", chain, err)
+		}
+		return nil
+	})
+}
+
+func (r *iptRuleMgr) Cleanup(netnsPath string, rdrct *Redirect) error {
+	table, chain := r.tableAndChain()
+	return r.withNetNS(netnsPath, func(ipt *iptables.IPTables) error {
+		if err := ipt.DeleteIfExists(table, "PREROUTING", "-j", chain); err != nil {
+			return fmt.Errorf("failed to unhook %s from PREROUTING: %v", chain, err)
+		}
+		if err := ipt.ClearAndDeleteChain(table, chain); err != nil {
+			return fmt.Errorf("failed to clear chain %s/%s: %v", table, chain, err)
+		}
+		return nil
+	})
+}
+
+func (r *iptRuleMgr) tableAndChain() (table, chain string) {
+	if r.tproxy {
+		return "mangle", istioTproxyChain
+	}
+	return "nat", istioInboundChain
+}
+
+// redirectRule builds the rule that captures iface's inbound TCP traffic into
+// rdrct.inboundPort, the proxy's inbound listener. rdrct.ports (the proxy's
+// own declared ports -- metrics, health, etc.) are excluded so the proxy's
+// own ports aren't redirected back into itself. This rule lives in PREROUTING,
+// where inbound packets have no local socket owner yet, so it cannot carry an
+// "-m owner" match -- that's only valid in OUTPUT/POSTROUTING.
+func (r *iptRuleMgr) redirectRule(rdrct *Redirect, iface string) []string {
+	rule := []string{"-i", iface, "-p", "tcp"}
+	if len(rdrct.ports) > 0 {
+		rule = append(rule, "-m", "multiport", "!", "--dports", strings.Join(rdrct.ports, ","))
+	}
+	if r.tproxy {
+		return append(rule, "-j", "TPROXY", "--tproxy-mark", "0x1/0x1", "--on-port", rdrct.inboundPort)
+	}
+	return append(rule, "-j", "REDIRECT", "--to-ports", rdrct.inboundPort)
+}
+
+// withNetNS locks the calling goroutine to its OS thread, switches into the
+// netns at netnsPath, runs fn with an iptables handle for that namespace, and
+// always restores the original netns before returning.
+func (r *iptRuleMgr) withNetNS(netnsPath string, fn func(*iptables.IPTables) error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origNS, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get current netns: %v", err)
+	}
+	defer origNS.Close()
+
+	targetNS, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %s: %v", netnsPath, err)
+	}
+	defer targetNS.Close()
+
+	if err := netns.Set(targetNS); err != nil {
+		return fmt.Errorf("failed to enter netns %s: %v", netnsPath, err)
+	}
+	defer func() {
+		if err := netns.Set(origNS); err != nil {
+			r.logger.Errorf("Failed to restore original netns: %v", err)
+		}
+	}()
+
+	ipt, err := iptables.New()
+	if err != nil {
+		return fmt.Errorf("failed to init iptables in netns %s: %v", netnsPath, err)
+	}
+	return fn(ipt)
+}
+
+func isChainExistsErr(err error) bool {
+	e, ok := err.(*iptables.Error)
+	return ok && e.ExitStatus() == 1
+}
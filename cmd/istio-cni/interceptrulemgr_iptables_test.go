@@ -0,0 +1,82 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedirectRuleExcludesOwnerMatch(t *testing.T) {
+	rdrct := &Redirect{
+		proxyUID:    "1337",
+		ports:       []string{"15090", "15021"},
+		inboundPort: "15006",
+	}
+
+	for _, mgr := range []*iptRuleMgr{{}, {tproxy: true}} {
+		rule := mgr.redirectRule(rdrct, "eth0")
+		for i, arg := range rule {
+			if arg == "owner" || arg == "--uid-owner" {
+				t.Fatalf("redirectRule(tproxy=%v) = %v; must not contain an owner match (invalid in PREROUTING), found %q at %d", mgr.tproxy, rule, arg, i)
+			}
+		}
+	}
+}
+
+func TestRedirectRuleREDIRECT(t *testing.T) {
+	rdrct := &Redirect{
+		proxyUID:    "1337",
+		ports:       []string{"15090", "15021"},
+		inboundPort: "15006",
+	}
+	mgr := &iptRuleMgr{}
+
+	got := mgr.redirectRule(rdrct, "eth0")
+	want := []string{
+		"-i", "eth0", "-p", "tcp",
+		"-m", "multiport", "!", "--dports", "15090,15021",
+		"-j", "REDIRECT", "--to-ports", "15006",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redirectRule() = %v, want %v", got, want)
+	}
+}
+
+func TestRedirectRuleTPROXY(t *testing.T) {
+	rdrct := &Redirect{
+		proxyUID:    "1337",
+		inboundPort: "15006",
+	}
+	mgr := &iptRuleMgr{tproxy: true}
+
+	got := mgr.redirectRule(rdrct, "eth0")
+	want := []string{
+		"-i", "eth0", "-p", "tcp",
+		"-j", "TPROXY", "--tproxy-mark", "0x1/0x1", "--on-port", "15006",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redirectRule() = %v, want %v", got, want)
+	}
+}
+
+func TestTableAndChain(t *testing.T) {
+	if table, chain := (&iptRuleMgr{}).tableAndChain(); table != "nat" || chain != istioInboundChain {
+		t.Errorf("tableAndChain() = %q/%q, want nat/%s", table, chain, istioInboundChain)
+	}
+	if table, chain := (&iptRuleMgr{tproxy: true}).tableAndChain(); table != "mangle" || chain != istioTproxyChain {
+		t.Errorf("tableAndChain(tproxy) = %q/%q, want mangle/%s", table, chain, istioTproxyChain)
+	}
+}
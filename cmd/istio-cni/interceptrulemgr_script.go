@@ -0,0 +1,74 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scriptRuleMgr preserves istio-cni's original behavior of shelling out to
+// istio-iptables.sh inside the target netns via nsenter. It is the default
+// backend, kept around for environments that don't yet trust the in-process
+// iptables/tproxy backends.
+//
+// istio-iptables.sh is supplied by the operator (PluginConf.Kubernetes.IptablesScript
+// defaults to "istio-iptables.sh" in PluginConf.Kubernetes.CniBinDir), so the flags
+// below are istio-cni's own contract for that script, not upstream Istio's
+// istio-iptables.sh flags. In particular there is no single setup/teardown
+// binary with overlapping short options: setup and cleanup are two distinct
+// invocations, selected by --mode, so a script that only implements one of
+// them fails fast instead of silently doing the wrong thing.
+type scriptRuleMgr struct {
+	logger *logrus.Entry
+}
+
+func (s *scriptRuleMgr) Program(netnsPath string, rdrct *Redirect) error {
+	return s.run(netnsPath, s.scriptArgs(rdrct, "--mode", "setup", "--inbound-ports", strings.Join(rdrct.ports, ",")))
+}
+
+func (s *scriptRuleMgr) Cleanup(netnsPath string, rdrct *Redirect) error {
+	return s.run(netnsPath, s.scriptArgs(rdrct, "--mode", "clean"))
+}
+
+// scriptArgs assembles the argument list shared by the setup and cleanup
+// invocations of istio-iptables.sh.
+func (s *scriptRuleMgr) scriptArgs(rdrct *Redirect, extra ...string) []string {
+	args := []string{"--proxy-uid", rdrct.proxyUID}
+	if rdrct.proxyGID != "" {
+		args = append(args, "--proxy-gid", rdrct.proxyGID)
+	}
+	if len(rdrct.interfaces) > 0 {
+		args = append(args, "--interfaces", strings.Join(rdrct.interfaces, ","))
+	}
+	return append(args, extra...)
+}
+
+// run execs istio-iptables.sh inside the target netns via nsenter.
+func (s *scriptRuleMgr) run(netnsPath string, scriptArgs []string) error {
+	nsenterArgs := []string{"--net=" + netnsPath, "--", fmt.Sprintf("%s/%s", nsSetupBinDir, nsSetupProg)}
+	nsenterArgs = append(nsenterArgs, scriptArgs...)
+
+	cmd := exec.Command("nsenter", nsenterArgs...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("istio-iptables.sh failed: %v: %s", err, string(out))
+	}
+	s.logger.Debugf("istio-iptables.sh output: %s", string(out))
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScriptArgs(t *testing.T) {
+	cases := []struct {
+		name  string
+		rdrct *Redirect
+		want  []string
+	}{
+		{
+			name:  "uid only",
+			rdrct: &Redirect{proxyUID: "1337"},
+			want:  []string{"--proxy-uid", "1337"},
+		},
+		{
+			name:  "uid and gid",
+			rdrct: &Redirect{proxyUID: "1337", proxyGID: "1337"},
+			want:  []string{"--proxy-uid", "1337", "--proxy-gid", "1337"},
+		},
+		{
+			name:  "uid and interfaces",
+			rdrct: &Redirect{proxyUID: "1337", interfaces: []string{"eth0", "net1"}},
+			want:  []string{"--proxy-uid", "1337", "--interfaces", "eth0,net1"},
+		},
+	}
+
+	s := &scriptRuleMgr{}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := s.scriptArgs(c.rdrct)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("scriptArgs() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestScriptArgsWithExtra(t *testing.T) {
+	s := &scriptRuleMgr{}
+	rdrct := &Redirect{proxyUID: "1337"}
+
+	got := s.scriptArgs(rdrct, "--mode", "clean")
+	want := []string{"--proxy-uid", "1337", "--mode", "clean"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("scriptArgs(extra) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+// redirectInterfacesAnnotation lets a pod opt into redirecting a specific,
+// explicit set of sandbox interfaces, overriding PluginConf.InterfaceSelector.
+// This is how multi-network pods (e.g. Multus chaining in an SR-IOV or OVN
+// interface alongside the mesh-facing one) keep dataplane interfaces untouched.
+const redirectInterfacesAnnotation = "traffic.sidecar.istio.io/redirectInterfaces"
+
+// defaultInterfaceSelector matches only the default pod interface, preserving
+// today's single-interface behavior when neither the annotation nor
+// InterfaceSelector is set.
+const defaultInterfaceSelector = "^eth0$"
+
+// selectRedirectInterfaces determines which of the sandbox's interfaces (as
+// reported in conf.PrevResult) should be redirected.
+//
+// Explicit requests are only possible via redirectInterfacesAnnotation: a
+// name listed there that isn't actually in the sandbox is an error, since the
+// pod asked for something istio-cni can't deliver. The implicit path
+// (conf.InterfaceSelector or the eth0 default) matching nothing is not an
+// error -- it means this sandbox has no interface for the selector to apply
+// to, so the caller should skip redirect for this pod rather than fail ADD
+// and break its networking entirely.
+func selectRedirectInterfaces(conf *PluginConf, annotations map[string]string) ([]string, error) {
+	if conf.PrevResult == nil {
+		return nil, fmt.Errorf("no prevResult to select interfaces from")
+	}
+
+	present := make(map[string]bool)
+	for _, iface := range sandboxInterfaces(conf.PrevResult) {
+		present[iface] = true
+	}
+
+	if val, ok := annotations[redirectInterfacesAnnotation]; ok && val != "" {
+		var wanted []string
+		for _, name := range strings.Split(val, ",") {
+			wanted = append(wanted, strings.TrimSpace(name))
+		}
+		for _, iface := range wanted {
+			if !present[iface] {
+				return nil, fmt.Errorf("requested redirect interface %q not found in sandbox", iface)
+			}
+		}
+		return wanted, nil
+	}
+
+	selector := conf.InterfaceSelector
+	if selector == "" {
+		selector = defaultInterfaceSelector
+	}
+	re, err := regexp.Compile(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface_selector %q: %v", selector, err)
+	}
+	var wanted []string
+	for _, iface := range sandboxInterfaces(conf.PrevResult) {
+		if re.MatchString(iface) {
+			wanted = append(wanted, iface)
+		}
+	}
+	return wanted, nil
+}
+
+// sandboxInterfaces returns the names of the interfaces prevResult reports as
+// living inside the pod's network namespace, i.e. those with a non-empty
+// Sandbox field.
+func sandboxInterfaces(result *current.Result) []string {
+	var names []string
+	for _, iface := range result.Interfaces {
+		if iface.Sandbox != "" {
+			names = append(names, iface.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/containernetworking/cni/pkg/types/current"
+)
+
+func resultWithInterfaces(names ...string) *current.Result {
+	result := &current.Result{}
+	for _, name := range names {
+		result.Interfaces = append(result.Interfaces, &current.Interface{Name: name, Sandbox: "/proc/1/ns/net"})
+	}
+	return result
+}
+
+func TestSelectRedirectInterfacesDefaultSelector(t *testing.T) {
+	conf := &PluginConf{PrevResult: resultWithInterfaces("eth0", "net1")}
+
+	got, err := selectRedirectInterfaces(conf, nil)
+	if err != nil {
+		t.Fatalf("selectRedirectInterfaces() error = %v", err)
+	}
+	if want := []string{"eth0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selectRedirectInterfaces() = %v, want %v", got, want)
+	}
+}
+
+func TestSelectRedirectInterfacesNoMatchIsNotAnError(t *testing.T) {
+	conf := &PluginConf{PrevResult: resultWithInterfaces("net1")}
+
+	got, err := selectRedirectInterfaces(conf, nil)
+	if err != nil {
+		t.Fatalf("selectRedirectInterfaces() error = %v, want nil (no match should skip, not fail ADD)", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("selectRedirectInterfaces() = %v, want empty", got)
+	}
+}
+
+func TestSelectRedirectInterfacesAnnotationOverride(t *testing.T) {
+	conf := &PluginConf{PrevResult: resultWithInterfaces("eth0", "net1")}
+	annotations := map[string]string{redirectInterfacesAnnotation: "net1"}
+
+	got, err := selectRedirectInterfaces(conf, annotations)
+	if err != nil {
+		t.Fatalf("selectRedirectInterfaces() error = %v", err)
+	}
+	if want := []string{"net1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selectRedirectInterfaces() = %v, want %v", got, want)
+	}
+}
+
+func TestSelectRedirectInterfacesAnnotationRequestsMissingInterface(t *testing.T) {
+	conf := &PluginConf{PrevResult: resultWithInterfaces("eth0")}
+	annotations := map[string]string{redirectInterfacesAnnotation: "net1"}
+
+	if _, err := selectRedirectInterfaces(conf, annotations); err == nil {
+		t.Fatal("selectRedirectInterfaces() error = nil, want error for an explicitly requested interface that's absent")
+	}
+}
+
+func TestSelectRedirectInterfacesNoPrevResult(t *testing.T) {
+	if _, err := selectRedirectInterfaces(&PluginConf{}, nil); err == nil {
+		t.Fatal("selectRedirectInterfaces() error = nil, want error when there's no prevResult to select from")
+	}
+}
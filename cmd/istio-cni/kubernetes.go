@@ -0,0 +1,91 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newKubeClient builds a Kubernetes clientset for the given plugin configuration,
+// preferring an explicit kubeconfig when one is supplied and falling back to the
+// in-cluster config (overriding the API root when requested).
+func newKubeClient(conf PluginConf, logger *logrus.Entry) (kubernetes.Interface, error) {
+	var config *rest.Config
+	var err error
+
+	if conf.Kubernetes.Kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", conf.Kubernetes.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build kube config from %q: %v", conf.Kubernetes.Kubeconfig, err)
+		}
+	} else {
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build in-cluster kube config: %v", err)
+		}
+	}
+
+	if conf.Kubernetes.K8sAPIRoot != "" {
+		config.Host = conf.Kubernetes.K8sAPIRoot
+	}
+
+	logger.Debugf("Built kube config: %v", config)
+
+	return kubernetes.NewForConfig(config)
+}
+
+// getKubePodInfo fetches the information needed to decide whether, and how, to
+// redirect a pod's traffic: whether it carries an Istio proxy container, the
+// names of its containers, its annotations, the ports the proxy cares about,
+// and the uid/gid the proxy runs as.
+func getKubePodInfo(client kubernetes.Interface, podName, podNamespace string) (
+	hasProxy bool, containers []string, initContainers []string, annotations map[string]string,
+	ports []string, proxyUID string, proxyGID string, err error) {
+	pod, err := client.CoreV1().Pods(podNamespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return false, nil, nil, nil, nil, "", "", fmt.Errorf("failed to get pod %s/%s: %v", podNamespace, podName, err)
+	}
+
+	annotations = pod.Annotations
+
+	for _, c := range pod.Spec.InitContainers {
+		initContainers = append(initContainers, c.Name)
+	}
+
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, c.Name)
+		if c.Name != "istio-proxy" {
+			continue
+		}
+		hasProxy = true
+		if c.SecurityContext != nil && c.SecurityContext.RunAsUser != nil {
+			proxyUID = fmt.Sprintf("%d", *c.SecurityContext.RunAsUser)
+		}
+		if c.SecurityContext != nil && c.SecurityContext.RunAsGroup != nil {
+			proxyGID = fmt.Sprintf("%d", *c.SecurityContext.RunAsGroup)
+		}
+		for _, p := range c.Ports {
+			ports = append(ports, fmt.Sprintf("%d", p.ContainerPort))
+		}
+	}
+
+	return hasProxy, containers, initContainers, annotations, ports, proxyUID, proxyGID, nil
+}
@@ -20,16 +20,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
-	"os"
 	"strconv"
-	"strings"
 
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
 	"github.com/containernetworking/cni/pkg/version"
-	"github.com/projectcalico/libcalico-go/lib/logutils"
+	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"istio.io/cni/pkg/log"
 )
 
 var (
@@ -40,6 +41,15 @@ var (
 	sidecarStatusKey    = "sidecar.istio.io/status"
 )
 
+// Scopes for istio-cni's three areas of concern: overall plugin flow, redirect
+// rule programming, and the (optional) apiserver lookups in kubernetes.go.
+// Each can be leveled independently via PluginConf.LogLevels.
+var (
+	pluginScope     = log.RegisterScope("plugin")
+	redirectScope   = log.RegisterScope("redirect")
+	kubeclientScope = log.RegisterScope("kubeclient")
+)
+
 // setupRedirect is a unit test override variable.
 var setupRedirect func(string, []string) error
 
@@ -75,6 +85,42 @@ type PluginConf struct {
 	// Add plugin-specific flags here
 	LogLevel   string     `json:"log_level"`
 	Kubernetes Kubernetes `json:"kubernetes"`
+
+	// LogLevels overrides LogLevel for individual scopes ("plugin",
+	// "redirect", "kubeclient"). A scope missing here uses LogLevel.
+	LogLevels map[string]string `json:"log_levels"`
+
+	// LogUDSAddress, when set, is a Unix domain socket every log record is
+	// additionally shipped to, since kubelet discards the plugin's stderr.
+	LogUDSAddress string `json:"log_uds_address"`
+
+	// LogJSON selects JSON-encoded log records instead of the log package's
+	// plain-text default.
+	LogJSON bool `json:"log_json"`
+
+	// LogOutputPath is where log records are written; "stderr" (the default)
+	// or a file path. RotationMaxSizeMB/RotationMaxAgeDays/RotationMaxBackups
+	// rotate it when it names a file.
+	LogOutputPath      string `json:"log_output_path"`
+	RotationMaxSizeMB  int    `json:"log_rotation_max_size_mb"`
+	RotationMaxAgeDays int    `json:"log_rotation_max_age_days"`
+	RotationMaxBackups int    `json:"log_rotation_max_backups"`
+
+	// InterceptType selects the InterceptRuleMgr backend used to program
+	// redirect rules: "script" (default, shells out to istio-iptables.sh),
+	// "iptables", or "tproxy".
+	InterceptType string `json:"intercept_type"`
+
+	// InterfaceSelector is a regex matched against PrevResult.Interfaces[].Name
+	// to decide which sandbox interfaces get redirected. Defaults to eth0
+	// only. A pod can override this per-pod via redirectInterfacesAnnotation.
+	InterfaceSelector string `json:"interface_selector"`
+
+	// CNIEventAddress is the Unix domain socket of a node-local agent that
+	// can answer pod-info lookups without cmdAdd hitting the apiserver
+	// itself. Defaults to defaultCNIEventAddress; if nothing is listening,
+	// cmdAdd falls back to talking to the apiserver directly.
+	CNIEventAddress string `json:"cni_event_address"`
 }
 
 // K8sArgs is the valid CNI_ARGS used for Kubernetes
@@ -116,28 +162,41 @@ func parseConfig(stdin []byte) (*PluginConf, error) {
 	return &conf, nil
 }
 
-// ConfigureLogging sets up logging using the provided log level,
-func ConfigureLogging(logLevel string) {
-	if strings.EqualFold(logLevel, "debug") {
-		logrus.SetLevel(logrus.DebugLevel)
-	} else if strings.EqualFold(logLevel, "info") {
-		logrus.SetLevel(logrus.InfoLevel)
-	} else {
-		// Default level
-		logrus.SetLevel(logrus.WarnLevel)
+// ConfigureLogging applies conf's logging settings -- JSON vs. plain-text,
+// UDS shipping to a node agent, and per-scope levels -- to the plugin,
+// redirect and kubeclient scopes.
+func ConfigureLogging(conf *PluginConf) {
+	o := log.GetLoggingOptions(conf.LogUDSAddress)
+	o.JSONEncoding = conf.LogJSON
+	if conf.LogOutputPath != "" {
+		o.OutputPath = conf.LogOutputPath
+	}
+	o.RotationMaxSizeMB = conf.RotationMaxSizeMB
+	o.RotationMaxAgeDays = conf.RotationMaxAgeDays
+	o.RotationMaxBackups = conf.RotationMaxBackups
+
+	defaultLevel := conf.LogLevel
+	for _, scope := range []string{pluginScope.Name(), redirectScope.Name(), kubeclientScope.Name()} {
+		if level, ok := conf.LogLevels[scope]; ok {
+			o.ScopeLevels[scope] = level
+		} else {
+			o.ScopeLevels[scope] = defaultLevel
+		}
 	}
 
-	logrus.SetOutput(os.Stderr)
+	if err := log.Configure(o); err != nil {
+		logrus.Errorf("Failed to configure logging: %v", err)
+	}
 }
 
 // cmdAdd is called for ADD requests
 func cmdAdd(args *skel.CmdArgs) error {
-	logrus.Info("istio-cni cmdAdd parsing config")
+	pluginScope.Infof("istio-cni cmdAdd parsing config")
 	conf, err := parseConfig(args.StdinData)
 	if err != nil {
 		return err
 	}
-	ConfigureLogging(conf.LogLevel)
+	ConfigureLogging(conf)
 
 	var loggedPrevResult interface{}
 	if conf.PrevResult == nil {
@@ -146,7 +205,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 		loggedPrevResult = conf.PrevResult
 	}
 
-	logrus.WithFields(logrus.Fields{
+	pluginScope.WithFields(logrus.Fields{
 		"version":    conf.CNIVersion,
 		"prevResult": loggedPrevResult,
 	}).Info("cmdAdd config parsed")
@@ -156,8 +215,8 @@ func cmdAdd(args *skel.CmdArgs) error {
 	if err := types.LoadArgs(args.Args, &k8sArgs); err != nil {
 		return err
 	}
-	logrus.Infof("Getting identifiers with arguments: %s", args.Args)
-	logrus.Infof("Loaded k8s arguments: %v", k8sArgs)
+	pluginScope.Infof("Getting identifiers with arguments: %s", args.Args)
+	pluginScope.Infof("Loaded k8s arguments: %v", k8sArgs)
 	if conf.Kubernetes.CniBinDir != "" {
 		nsSetupBinDir = conf.Kubernetes.CniBinDir
 	}
@@ -168,7 +227,7 @@ func cmdAdd(args *skel.CmdArgs) error {
 	podName := string(k8sArgs.K8S_POD_NAME)
 	podNamespace := string(k8sArgs.K8S_POD_NAMESPACE)
 
-	logger := logrus.WithFields(logrus.Fields{
+	logger := pluginScope.WithFields(logrus.Fields{
 		"ContainerID": args.ContainerID,
 		"Pod":         podName,
 		"Namespace":   podNamespace,
@@ -184,51 +243,76 @@ func cmdAdd(args *skel.CmdArgs) error {
 			}
 		}
 		if !excludePod {
-			client, err := newKubeClient(*conf, logger)
-			if err != nil {
-				return err
-			}
-			logrus.WithField("client", client).Debug("Created Kubernetes client")
-			hasProxy, containers, _, annotations, ports, proxyUID, proxyGID, err := getKubePodInfo(client, podName, podNamespace)
+			hasProxy, containers, annotations, ports, proxyUID, proxyGID, err := getPodInfo(conf, args, podName, podNamespace, logger)
 			if err != nil {
 				logger.Errorf("Error getting Pod data %v", err)
 				return err
 			}
 			logger.Infof("Found containers %v", containers)
 			if hasProxy && len(containers) > 1 {
-				logrus.WithFields(logrus.Fields{
-					"ContainerID": args.ContainerID,
+				logger.WithFields(logrus.Fields{
 					"netns":       args.Netns,
-					"pod":         podName,
-					"Namespace":   podNamespace,
 					"ports":       ports,
 					"annotations": annotations,
 				}).Infof("Checking annotations prior to redirect for Istio proxy")
 
 				if val, ok := annotations[injectAnnotationKey]; ok {
-					logrus.Infof("Pod %s contains inject annotation: %s", podName, val)
+					logger.Infof("Pod %s contains inject annotation: %s", podName, val)
 					if injectEnabled, err := strconv.ParseBool(val); err == nil {
 						if !injectEnabled {
-							logrus.Infof("Pod excluded due to inject-disabled annotation")
+							logger.Infof("Pod excluded due to inject-disabled annotation")
 							excludePod = true
 						}
 					}
 				}
 				if _, ok := annotations[sidecarStatusKey]; !ok {
-					logrus.Infof("Pod %s excluded due to not containing sidecar annotation", podName)
+					logger.Infof("Pod %s excluded due to not containing sidecar annotation", podName)
 					excludePod = true
 				}
 				if !excludePod {
-					logrus.Infof("setting up redirect")
-					if redirect, err := NewRedirect(proxyUID, proxyGID, ports, annotations, logger); err != nil {
+					existing, err := loadContainerState(args.ContainerID)
+					if err != nil {
+						logger.Warnf("Failed to load existing redirect state, proceeding as a fresh ADD: %v", err)
+					}
+					if existing != nil {
+						logger.Info("Redirect already configured for this container, reconciling instead of re-appending rules")
+						if stale, err := redirectFromState(existing, redirectScope.WithFields(logger.Data)); err != nil {
+							logger.Warnf("Failed to rebuild stale redirect: %v", err)
+						} else if err := stale.clear(existing.Netns); err != nil {
+							logger.Warnf("Failed to clear stale redirect before reconciling: %v", err)
+						}
+					}
+
+					redirectInterfaces, err := selectRedirectInterfaces(conf, annotations)
+					if err != nil {
+						logger.Errorf("Failed to select redirect interfaces: %v", err)
+						return err
+					}
+
+					if len(redirectInterfaces) == 0 {
+						logger.Infof("No sandbox interface matched for redirect, skipping")
+					} else if redirect, err := NewRedirect(proxyUID, proxyGID, ports, annotations, conf.InterceptType, redirectInterfaces, redirectScope.WithFields(logger.Data)); err != nil {
 						logger.Errorf("Pod redirect failed due to bad params: %v", err)
 						return err
 					} else {
+						logger.Infof("setting up redirect")
 						if setupRedirect != nil {
 							_ = setupRedirect(args.Netns, ports)
 						} else if err := redirect.doRedirect(args.Netns); err != nil {
 							return err
 						}
+						if err := saveContainerState(&containerState{
+							ContainerID:   args.ContainerID,
+							Netns:         args.Netns,
+							ProxyUID:      redirect.proxyUID,
+							ProxyGID:      redirect.proxyGID,
+							Ports:         redirect.ports,
+							InboundPort:   redirect.inboundPort,
+							Interfaces:    redirect.interfaces,
+							InterceptType: conf.InterceptType,
+						}); err != nil {
+							logger.Warnf("Failed to persist redirect state, DEL will not be able to flush rules: %v", err)
+						}
 					}
 				}
 			}
@@ -251,34 +335,114 @@ func cmdAdd(args *skel.CmdArgs) error {
 	return types.PrintResult(result, conf.CNIVersion)
 }
 
-func cmdGet(args *skel.CmdArgs) error {
-	logrus.Info("cmdGet not implemented")
-	// TODO: implement
-	return fmt.Errorf("not implemented")
+// cmdCheck is called for CHECK requests. Per the CNI 0.4.0+ CHECK contract we
+// verify that the netns and interfaces this plugin was handed in prevResult
+// still match the sandbox, and that any saved redirect state still names this
+// netns and only interfaces present in prevResult. The installed rules
+// themselves are not inspected, and a container with no saved state passes.
+func cmdCheck(args *skel.CmdArgs) error {
+	pluginScope.Infof("istio-cni cmdCheck parsing config")
+	conf, err := parseConfig(args.StdinData)
+	if err != nil {
+		return err
+	}
+	ConfigureLogging(conf)
+
+	logger := pluginScope.WithFields(logrus.Fields{"ContainerID": args.ContainerID})
+
+	if conf.PrevResult == nil {
+		logger.Debug("No prevResult to check against")
+		return nil
+	}
+
+	if err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		for _, iface := range conf.PrevResult.Interfaces {
+			if iface.Sandbox == "" {
+				// Host-side interface; nothing to check inside the netns.
+				continue
+			}
+			if _, err := netlink.LinkByName(iface.Name); err != nil {
+				return fmt.Errorf("interface %q from prevResult not found in netns %s: %v", iface.Name, args.Netns, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("cmdCheck failed to validate netns %s: %v", args.Netns, err)
+	}
+
+	state, err := loadContainerState(args.ContainerID)
+	if err != nil {
+		return fmt.Errorf("cmdCheck failed to load redirect state: %v", err)
+	}
+	if state != nil && state.Netns != args.Netns {
+		return fmt.Errorf("cmdCheck found redirect state for stale netns %s, expected %s", state.Netns, args.Netns)
+	}
+	if state != nil {
+		present := make(map[string]bool)
+		for _, name := range sandboxInterfaces(conf.PrevResult) {
+			present[name] = true
+		}
+		for _, iface := range state.Interfaces {
+			if !present[iface] {
+				return fmt.Errorf("redirected interface %q from saved state no longer present in sandbox", iface)
+			}
+		}
+	}
+
+	logger.Info("cmdCheck passed")
+	return nil
 }
 
-// cmdDel is called for DELETE requests
+// cmdDel is called for DELETE requests. It flushes whatever redirect rules
+// cmdAdd installed for this container, using the state cmdAdd recorded, so
+// that replayed or repeated DELs never leave redirect rules behind.
 func cmdDel(args *skel.CmdArgs) error {
-	logrus.Info("istio-cni cmdDel parsing config")
+	pluginScope.Infof("istio-cni cmdDel parsing config")
 	conf, err := parseConfig(args.StdinData)
 	if err != nil {
 		return err
 	}
-	ConfigureLogging(conf.LogLevel)
-	_ = conf
+	ConfigureLogging(conf)
 
-	// Do your delete here
+	logger := pluginScope.WithFields(logrus.Fields{"ContainerID": args.ContainerID})
 
-	return nil
+	state, err := loadContainerState(args.ContainerID)
+	if err != nil {
+		return fmt.Errorf("cmdDel failed to load redirect state: %v", err)
+	}
+	if state == nil {
+		logger.Info("No redirect state found for this container, nothing to tear down")
+		return nil
+	}
+
+	netns := args.Netns
+	if netns == "" {
+		// The netns may already be gone by the time DEL runs; fall back to
+		// the one recorded at ADD time so we can still try to clean up.
+		netns = state.Netns
+	}
+
+	redirect, err := redirectFromState(state, redirectScope.WithFields(logger.Data))
+	if err != nil {
+		return fmt.Errorf("cmdDel failed to rebuild redirect: %v", err)
+	}
+	if err := redirect.clear(netns); err != nil {
+		logger.Warnf("Failed to flush redirect rules for netns %s (it may already be torn down): %v", netns, err)
+	}
+
+	return removeContainerState(args.ContainerID)
 }
 
 func main() {
-	// Set up logging formatting.
-	logrus.SetFormatter(&logutils.Formatter{})
-
-	// Install a hook that adds file/line no information.
-	logrus.AddHook(&logutils.ContextHook{})
+	// Log at the default level until ConfigureLogging applies the config
+	// read from stdin inside each cmd* handler.
+	if err := log.Configure(log.DefaultOptions()); err != nil {
+		logrus.Errorf("Failed to configure default logging: %v", err)
+	}
 
-	// TODO: implement plugin version
-	skel.PluginMain(cmdAdd, cmdGet, cmdDel, version.All, "istio-cni")
+	skel.PluginMainFuncs(skel.CNIFuncs{
+		Add:   cmdAdd,
+		Check: cmdCheck,
+		Del:   cmdDel,
+	}, version.All, "istio-cni")
 }
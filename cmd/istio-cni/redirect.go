@@ -0,0 +1,125 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// istioProxyUIDAnnotation and istioProxyGIDAnnotation let an operator override
+// the proxy uid/gid discovered from the pod spec via annotations.
+const (
+	istioProxyUIDAnnotation = "sidecar.istio.io/proxyUID"
+	istioProxyGIDAnnotation = "sidecar.istio.io/proxyGID"
+)
+
+// defaultInboundPort is the port Envoy's inbound listener binds to, and the
+// port inbound interception must redirect into -- not proxyUID, which is a
+// uid, not a port.
+const defaultInboundPort = "15006"
+
+// Redirect captures everything needed to program, and later tear down, the
+// rules that redirect a pod's traffic through its Istio proxy. The actual
+// mechanics of programming those rules are delegated to an InterceptRuleMgr,
+// so Redirect itself stays agnostic to script vs. in-process backends.
+type Redirect struct {
+	proxyUID    string
+	proxyGID    string
+	ports       []string
+	inboundPort string
+	interfaces  []string
+	ruleMgr     InterceptRuleMgr
+	logger      *logrus.Entry
+}
+
+// NewRedirect validates the parameters gathered from the pod's spec and
+// annotations and returns a Redirect ready to be applied to a netns using the
+// InterceptRuleMgr backend named by interceptType, scoped to interfaces.
+func NewRedirect(proxyUID, proxyGID string, ports []string, annotations map[string]string, interceptType string, interfaces []string, logger *logrus.Entry) (*Redirect, error) {
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("pod has no ports to redirect")
+	}
+	if len(interfaces) == 0 {
+		return nil, fmt.Errorf("no interfaces selected for redirect")
+	}
+	if val, ok := annotations[istioProxyUIDAnnotation]; ok {
+		proxyUID = val
+	}
+	if val, ok := annotations[istioProxyGIDAnnotation]; ok {
+		proxyGID = val
+	}
+	if proxyUID == "" {
+		return nil, fmt.Errorf("unable to determine proxy UID")
+	}
+
+	ruleMgr, err := newInterceptRuleMgr(interceptType, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Redirect{
+		proxyUID:    proxyUID,
+		proxyGID:    proxyGID,
+		ports:       ports,
+		inboundPort: defaultInboundPort,
+		interfaces:  interfaces,
+		ruleMgr:     ruleMgr,
+		logger:      logger,
+	}, nil
+}
+
+// redirectFromState rebuilds the Redirect that produced a saved
+// containerState, so cmdDel and the cmdAdd reconcile path can tear down rules
+// without needing the original pod annotations.
+func redirectFromState(state *containerState, logger *logrus.Entry) (*Redirect, error) {
+	ruleMgr, err := newInterceptRuleMgr(state.InterceptType, logger)
+	if err != nil {
+		return nil, err
+	}
+	inboundPort := state.InboundPort
+	if inboundPort == "" {
+		inboundPort = defaultInboundPort
+	}
+	return &Redirect{
+		proxyUID:    state.ProxyUID,
+		proxyGID:    state.ProxyGID,
+		ports:       state.Ports,
+		inboundPort: inboundPort,
+		interfaces:  state.Interfaces,
+		ruleMgr:     ruleMgr,
+		logger:      logger,
+	}, nil
+}
+
+// doRedirect installs the redirect rules for this pod in the given netns.
+func (rdrct *Redirect) doRedirect(netns string) error {
+	if rdrct == nil {
+		return fmt.Errorf("redirect is nil")
+	}
+	rdrct.logger.WithField("netns", netns).Info("Setting up redirect")
+	return rdrct.ruleMgr.Program(netns, rdrct)
+}
+
+// clear reverses doRedirect, removing whatever rules were installed so that a
+// DEL (or a repeated ADD) never leaves stale rules behind.
+func (rdrct *Redirect) clear(netns string) error {
+	if rdrct == nil {
+		return nil
+	}
+	rdrct.logger.WithField("netns", netns).Info("Tearing down redirect")
+	return rdrct.ruleMgr.Cleanup(netns, rdrct)
+}
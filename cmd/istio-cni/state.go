@@ -0,0 +1,90 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// stateDir holds one JSON file per container for which cmdAdd has installed
+// redirect rules, so cmdDel (and replayed cmdAdd calls) know what to undo.
+var stateDir = "/var/lib/istio-cni/"
+
+// containerState is the subset of redirect configuration that must survive
+// between cmdAdd and a later cmdDel/cmdCheck for the same ContainerID.
+type containerState struct {
+	ContainerID   string   `json:"containerId"`
+	Netns         string   `json:"netns"`
+	ProxyUID      string   `json:"proxyUid"`
+	ProxyGID      string   `json:"proxyGid"`
+	Ports         []string `json:"ports"`
+	InboundPort   string   `json:"inboundPort"`
+	Interfaces    []string `json:"interfaces"`
+	InterceptType string   `json:"interceptType"`
+}
+
+func stateFilePath(containerID string) string {
+	return filepath.Join(stateDir, containerID+".json")
+}
+
+// saveContainerState persists the redirect state for containerID, creating
+// stateDir if it doesn't already exist.
+func saveContainerState(state *containerState) error {
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", stateDir, err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal container state: %v", err)
+	}
+
+	if err := ioutil.WriteFile(stateFilePath(state.ContainerID), data, 0600); err != nil {
+		return fmt.Errorf("failed to write container state: %v", err)
+	}
+	return nil
+}
+
+// loadContainerState returns the previously saved state for containerID, or
+// (nil, nil) if no state file exists.
+func loadContainerState(containerID string) (*containerState, error) {
+	data, err := ioutil.ReadFile(stateFilePath(containerID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container state: %v", err)
+	}
+
+	state := &containerState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal container state: %v", err)
+	}
+	return state, nil
+}
+
+// removeContainerState deletes the on-disk state for containerID. It is not
+// an error for the state to already be absent, since DEL may be replayed.
+func removeContainerState(containerID string) error {
+	err := os.Remove(stateFilePath(containerID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove container state: %v", err)
+	}
+	return nil
+}
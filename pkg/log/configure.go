@@ -0,0 +1,77 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"io"
+	"os"
+
+	"github.com/projectcalico/libcalico-go/lib/logutils"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Configure applies o to every Scope registered so far. A Scope registered
+// after the call is not configured by it, so callers should Configure after
+// all of a binary's scopes have been created.
+func Configure(o *Options) error {
+	output := outputWriter(o)
+	formatter := formatterFor(o)
+
+	var hook logrus.Hook
+	if o.UDSAddress != "" {
+		hook = newUDSHook(o.UDSAddress)
+	}
+
+	scopesMu.Lock()
+	defer scopesMu.Unlock()
+
+	for name, scope := range scopes {
+		scope.logger.SetOutput(output)
+		scope.logger.SetFormatter(formatter)
+		scope.logger.Hooks = make(logrus.LevelHooks)
+		if hook != nil {
+			scope.logger.AddHook(hook)
+		}
+
+		level := o.ScopeLevels[name]
+		if level == "" {
+			level = defaultLevel
+		}
+		scope.logger.SetLevel(parseLevel(level))
+	}
+
+	return nil
+}
+
+func outputWriter(o *Options) io.Writer {
+	if o.OutputPath == "" || o.OutputPath == "stderr" {
+		return os.Stderr
+	}
+
+	return &lumberjack.Logger{
+		Filename:   o.OutputPath,
+		MaxSize:    o.RotationMaxSizeMB,
+		MaxAge:     o.RotationMaxAgeDays,
+		MaxBackups: o.RotationMaxBackups,
+	}
+}
+
+func formatterFor(o *Options) logrus.Formatter {
+	if o.JSONEncoding {
+		return &logrus.JSONFormatter{}
+	}
+	return &logutils.Formatter{}
+}
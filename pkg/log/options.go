@@ -0,0 +1,67 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package log provides istio-cni's logging setup: leveled, named scopes that
+// can be configured independently, optional JSON encoding for ingestion by
+// fluent-bit/Loki, optional rotation of the on-disk output, and optional
+// shipping of every record over a UDS to a node agent -- since kubelet
+// discards whatever the CNI plugin binary writes to stderr.
+package log
+
+// Options configures the logging for every registered Scope.
+type Options struct {
+	// OutputPath is where log records are written; "stderr" (the default) or
+	// a file path.
+	OutputPath string
+
+	// JSONEncoding, when true, formats records as JSON instead of istio-cni's
+	// historical plain-text format.
+	JSONEncoding bool
+
+	// RotationMaxSizeMB, RotationMaxAgeDays and RotationMaxBackups control
+	// rotation of OutputPath when it names a file. They are ignored when
+	// OutputPath is "stderr".
+	RotationMaxSizeMB  int
+	RotationMaxAgeDays int
+	RotationMaxBackups int
+
+	// UDSAddress, when non-empty, is a Unix domain socket that every log
+	// record is additionally shipped to, best-effort, for a node agent to
+	// collect and forward.
+	UDSAddress string
+
+	// ScopeLevels maps a Scope's name to the minimum level it should log at.
+	// A scope with no entry here uses defaultLevel.
+	ScopeLevels map[string]string
+}
+
+const defaultLevel = "warn"
+
+// DefaultOptions returns the options istio-cni used before this package
+// existed: warn-level, plain-text, stderr-only, no rotation, no UDS shipping.
+func DefaultOptions() *Options {
+	return &Options{
+		OutputPath:  "stderr",
+		ScopeLevels: map[string]string{},
+	}
+}
+
+// GetLoggingOptions returns the default Options configured to additionally
+// ship every record to the node agent listening on udsAddress. Pass an empty
+// string to skip UDS shipping entirely.
+func GetLoggingOptions(udsAddress string) *Options {
+	o := DefaultOptions()
+	o.UDSAddress = udsAddress
+	return o
+}
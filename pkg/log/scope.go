@@ -0,0 +1,83 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Scope is a named logger that can have its own level and its own set of
+// contextual fields, while still sharing the process-wide output, format and
+// UDS shipping that Configure sets up.
+type Scope struct {
+	name   string
+	logger *logrus.Logger
+}
+
+var (
+	scopesMu sync.Mutex
+	scopes   = map[string]*Scope{}
+)
+
+// RegisterScope returns the Scope named name, creating it on first use. It is
+// safe to call RegisterScope with the same name from multiple packages/init
+// functions; they all get the same Scope.
+func RegisterScope(name string) *Scope {
+	scopesMu.Lock()
+	defer scopesMu.Unlock()
+
+	if s, ok := scopes[name]; ok {
+		return s
+	}
+
+	s := &Scope{
+		name:   name,
+		logger: logrus.New(),
+	}
+	scopes[name] = s
+	return s
+}
+
+// Name returns the scope's registered name.
+func (s *Scope) Name() string {
+	return s.name
+}
+
+// WithFields returns a log entry for this scope carrying fields, e.g. a
+// pod's ContainerID/Pod/Namespace.
+func (s *Scope) WithFields(fields logrus.Fields) *logrus.Entry {
+	return s.logger.WithFields(fields).WithField("scope", s.name)
+}
+
+func (s *Scope) Debugf(format string, args ...interface{}) { s.logger.Debugf(format, args...) }
+func (s *Scope) Infof(format string, args ...interface{})  { s.logger.Infof(format, args...) }
+func (s *Scope) Warnf(format string, args ...interface{})  { s.logger.Warnf(format, args...) }
+func (s *Scope) Errorf(format string, args ...interface{}) { s.logger.Errorf(format, args...) }
+
+func parseLevel(level string) logrus.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return logrus.DebugLevel
+	case "info":
+		return logrus.InfoLevel
+	case "error":
+		return logrus.ErrorLevel
+	default:
+		return logrus.WarnLevel
+	}
+}
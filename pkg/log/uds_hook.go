@@ -0,0 +1,91 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package log
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// udsHook ships every fired log record to a node agent over a Unix domain
+// socket, best-effort: kubelet discards the CNI plugin binary's stderr, so
+// this is the only way those records reach anywhere durable. Failures to
+// ship never fail the log call itself.
+type udsHook struct {
+	address string
+	dialer  net.Dialer
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func newUDSHook(address string) *udsHook {
+	return &udsHook{
+		address: address,
+		dialer:  net.Dialer{Timeout: 500 * time.Millisecond},
+	}
+}
+
+func (h *udsHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *udsHook) Fire(entry *logrus.Entry) error {
+	line, err := entry.Bytes()
+	if err != nil {
+		return nil
+	}
+
+	conn, err := h.connection()
+	if err != nil {
+		// The node agent may simply not be running yet; don't spam by
+		// returning an error logrus would otherwise print to stderr.
+		return nil
+	}
+
+	if _, err := conn.Write(append(line, '\n')); err != nil {
+		h.reset()
+	}
+	return nil
+}
+
+func (h *udsHook) connection() (net.Conn, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		return h.conn, nil
+	}
+
+	conn, err := h.dialer.Dial("unix", h.address)
+	if err != nil {
+		return nil, err
+	}
+	h.conn = conn
+	return conn, nil
+}
+
+func (h *udsHook) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+	}
+}
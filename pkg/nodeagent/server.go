@@ -0,0 +1,150 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nodeagent implements the node-local daemon that the istio-cni
+// plugin binary talks to over a Unix domain socket, so that cmdAdd does not
+// need to hit the apiserver on every pod start. The server answers CNI events
+// with pod info from a PodInfoCache, the info the plugin would otherwise have
+// fetched directly. It does not program iptables rules; cmdAdd still does that.
+package nodeagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PodInfo is what the agent's informer cache returns for a pod.
+type PodInfo struct {
+	HasProxy    bool
+	Containers  []string
+	Annotations map[string]string
+	Ports       []string
+	ProxyUID    string
+	ProxyGID    string
+}
+
+// PodInfoCache is the pod store the server looks pod info up in, such as one
+// backed by an informer; this package does not provide an implementation. It
+// is an interface so the HTTP handler can be unit tested without a real
+// apiserver watch running.
+type PodInfoCache interface {
+	GetPodInfo(namespace, name string) (*PodInfo, error)
+}
+
+// Server answers CNI events pushed by the istio-cni plugin binary over a Unix
+// domain socket.
+type Server struct {
+	socketPath string
+	cache      PodInfoCache
+	logger     *logrus.Entry
+}
+
+// NewServer returns a Server that will listen on socketPath and resolve pod
+// info from cache.
+func NewServer(socketPath string, cache PodInfoCache, logger *logrus.Entry) *Server {
+	return &Server{
+		socketPath: socketPath,
+		cache:      cache,
+		logger:     logger,
+	}
+}
+
+// ListenAndServe creates the Unix domain socket (removing any stale one left
+// behind by a previous run) and serves until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if err := os.RemoveAll(s.socketPath); err != nil {
+		return fmt.Errorf("failed to clear stale socket %s: %v", s.socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", s.socketPath, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cni-event", s.handleCNIEvent)
+	httpServer := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		_ = httpServer.Close()
+	}()
+
+	s.logger.Infof("Listening for CNI events on %s", s.socketPath)
+	if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// cniEvent mirrors cmd/istio-cni's CNIEvent wire format.
+type cniEvent struct {
+	ContainerID   string   `json:"containerId"`
+	Netns         string   `json:"netns"`
+	PodName       string   `json:"podName"`
+	PodNamespace  string   `json:"podNamespace"`
+	PrevResultIPs []string `json:"prevResultIps"`
+	Args          string   `json:"args"`
+}
+
+// cniEventResponse mirrors cmd/istio-cni's CNIEventResponse wire format.
+type cniEventResponse struct {
+	HasProxy    bool              `json:"hasProxy"`
+	Containers  []string          `json:"containers"`
+	Annotations map[string]string `json:"annotations"`
+	Ports       []string          `json:"ports"`
+	ProxyUID    string            `json:"proxyUid"`
+	ProxyGID    string            `json:"proxyGid"`
+}
+
+func (s *Server) handleCNIEvent(w http.ResponseWriter, r *http.Request) {
+	var event cniEvent
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode CNI event: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	logger := s.logger.WithFields(logrus.Fields{
+		"ContainerID": event.ContainerID,
+		"Pod":         event.PodName,
+		"Namespace":   event.PodNamespace,
+	})
+
+	info, err := s.cache.GetPodInfo(event.PodNamespace, event.PodName)
+	if err != nil {
+		logger.Errorf("Failed to look up pod info: %v", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp := cniEventResponse{
+		HasProxy:    info.HasProxy,
+		Containers:  info.Containers,
+		Annotations: info.Annotations,
+		Ports:       info.Ports,
+		ProxyUID:    info.ProxyUID,
+		ProxyGID:    info.ProxyGID,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("Failed to encode CNI event response: %v", err)
+	}
+}